@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const jwksCacheTTL = 10 * time.Minute
+
+// jwksKey is a single entry of a JSON Web Key Set, as served by an OIDC
+// provider's jwks_uri.
+type jwksKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDocument struct {
+	Keys []jwksKey `json:"keys"`
+}
+
+type cachedJWKS struct {
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// jwksCache fetches and caches RSA public keys per JWKS URL, so route auth
+// checks don't hit the network on every request.
+type jwksCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedJWKS
+}
+
+var defaultJWKSCache = &jwksCache{entries: make(map[string]*cachedJWKS)}
+
+func (c *jwksCache) keyFunc(jwksURL string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		keys, err := c.get(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	}
+}
+
+func (c *jwksCache) get(jwksURL string) (map[string]*rsa.PublicKey, error) {
+	c.mu.Lock()
+	entry, fresh := c.entries[jwksURL]
+	c.mu.Unlock()
+
+	if fresh && time.Since(entry.fetchedAt) < jwksCacheTTL {
+		return entry.keys, nil
+	}
+
+	keys, err := fetchJWKS(jwksURL)
+	if err != nil {
+		if fresh {
+			return entry.keys, nil // serve stale keys rather than fail open/closed on a blip
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[jwksURL] = &cachedJWKS{keys: keys, fetchedAt: time.Now()}
+	c.mu.Unlock()
+
+	return keys, nil
+}
+
+func fetchJWKS(jwksURL string) (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching JWKS: %d", resp.StatusCode)
+	}
+
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		pub, err := rsaPublicKeyFromJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	return keys, nil
+}
+
+func rsaPublicKeyFromJWK(k jwksKey) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+// authorizeRoute enforces a route's JWKS-based bearer auth, if configured.
+// It writes a 401 response and returns false when the request should be
+// rejected; routes with no JWKSURL are always authorized.
+func authorizeRoute(c *gin.Context, route Route) bool {
+	if route.JWKSURL == "" {
+		return true
+	}
+
+	const prefix = "Bearer "
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Missing bearer token",
+		})
+		return false
+	}
+
+	tokenString := strings.TrimPrefix(header, prefix)
+
+	token, err := jwt.Parse(tokenString, defaultJWKSCache.keyFunc(route.JWKSURL), jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil || !token.Valid {
+		logger.WithError(err).Warn("Bearer token verification failed")
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid bearer token",
+		})
+		return false
+	}
+
+	return true
+}
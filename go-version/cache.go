@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultCacheMaxItems = 10000
+	defaultCacheMaxBytes = 256 * 1024 * 1024 // 256 MiB
+	defaultCacheTTL      = 5 * time.Minute
+)
+
+var (
+	objectCache Cache
+	cacheTTL    time.Duration
+	fetchGroup  singleflight.Group
+)
+
+// CachedObject is the cached representation of a GCS object: its bytes plus
+// the attributes needed to answer conditional requests and set headers.
+type CachedObject struct {
+	Data            []byte
+	ContentType     string
+	ContentEncoding string // "", "br", or "gzip"; see compress.go
+	ETag            string
+	Size            int64
+	Updated         time.Time
+}
+
+// cacheState describes the freshness of a cache lookup result.
+type cacheState int
+
+const (
+	cacheMiss cacheState = iota
+	cacheFresh
+	cacheStale
+)
+
+// CacheStats summarizes cache activity for the /health endpoint.
+type CacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Stale       uint64 `json:"stale"`
+	Revalidated uint64 `json:"revalidated"`
+	Items       int    `json:"items"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// Cache is the pluggable subsystem consulted before falling back to GCS.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	Lookup(key string) (*CachedObject, cacheState)
+	Store(key string, obj *CachedObject, ttl time.Duration)
+	Invalidate(key string)
+	MarkRevalidated()
+	Stats() CacheStats
+}
+
+// initializeCache sets up the package-level objectCache from env vars. It
+// is safe to call even when caching ends up disabled (e.g. LRU init
+// failure); proxyHandler treats a nil objectCache as "caching off".
+func initializeCache() {
+	maxBytes := int64(defaultCacheMaxBytes)
+	if v := os.Getenv("CACHE_MAX_BYTES"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed > 0 {
+			maxBytes = parsed
+		} else {
+			logger.WithField("value", v).Warn("Invalid CACHE_MAX_BYTES, using default")
+		}
+	}
+
+	cacheTTL = defaultCacheTTL
+	if v := os.Getenv("CACHE_TTL"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			cacheTTL = parsed
+		} else {
+			logger.WithField("value", v).Warn("Invalid CACHE_TTL, using default")
+		}
+	}
+
+	mem, err := newMemoryCache(defaultCacheMaxItems, maxBytes)
+	if err != nil {
+		logger.WithError(err).Error("Failed to initialize in-memory cache; caching disabled")
+		return
+	}
+
+	tiered := &tieredCache{mem: mem}
+
+	if dir := os.Getenv("CACHE_DISK_DIR"); dir != "" {
+		disk, err := newDiskCache(dir)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to initialize disk cache tier; continuing with memory only")
+		} else {
+			tiered.disk = disk
+			logger.WithField("dir", dir).Info("Disk cache tier enabled")
+		}
+	}
+
+	objectCache = tiered
+
+	logger.WithFields(logrus.Fields{
+		"max_bytes": maxBytes,
+		"ttl":       cacheTTL.String(),
+	}).Info("Object cache initialized")
+}
+
+// fetchAndCache fetches an object's full body from GCS, coalescing
+// concurrent calls for the same key via singleflight so only one of them
+// hits GCS, then stores the result in objectCache. When encoding is
+// non-empty, the body is compressed before caching so the key's
+// Accept-Encoding variant is served straight from memory next time.
+func fetchAndCache(ctx context.Context, obj *storage.ObjectHandle, key string, attrs *storage.ObjectAttrs, contentType, encoding string) (*CachedObject, error) {
+	v, err, _ := fetchGroup.Do(key, func() (interface{}, error) {
+		reader, err := obj.NewReader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = compressData(data, encoding)
+		if err != nil {
+			return nil, err
+		}
+
+		cached := &CachedObject{
+			Data:            data,
+			ContentType:     contentType,
+			ContentEncoding: encoding,
+			ETag:            attrs.Etag,
+			Size:            attrs.Size,
+			Updated:         attrs.Updated,
+		}
+
+		if objectCache != nil {
+			objectCache.Store(key, cached, cacheTTL)
+		}
+
+		return cached, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*CachedObject), nil
+}
+
+// invalidateAllVariants invalidates baseKey (the uncompressed cache entry)
+// along with every compressed variant proxyHandler may have stored under
+// baseKey+"|"+encoding. Callers that only know the bucket/object, not which
+// Accept-Encoding variants a client negotiated, should use this instead of
+// Cache.Invalidate directly so compressed variants don't linger stale.
+func invalidateAllVariants(cache Cache, baseKey string) {
+	cache.Invalidate(baseKey)
+	for _, encoding := range cacheableEncodings {
+		cache.Invalidate(baseKey + "|" + encoding)
+	}
+}
+
+// revalidateCache re-checks a stale cache entry against GCS in the
+// background, refetching the body only if the ETag changed.
+func revalidateCache(key string, obj *storage.ObjectHandle, stale *CachedObject) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"cache_key": key,
+			"error":     err.Error(),
+		}).Warn("Background cache revalidation failed")
+		return
+	}
+
+	if attrs.Etag == stale.ETag {
+		objectCache.Store(key, stale, cacheTTL)
+		objectCache.MarkRevalidated()
+		return
+	}
+
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"cache_key": key,
+			"error":     err.Error(),
+		}).Warn("Background cache re-fetch failed")
+		return
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"cache_key": key,
+			"error":     err.Error(),
+		}).Warn("Background cache re-fetch failed")
+		return
+	}
+
+	data, err = compressData(data, stale.ContentEncoding)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"cache_key": key,
+			"error":     err.Error(),
+		}).Warn("Background cache re-fetch failed")
+		return
+	}
+
+	objectCache.Store(key, &CachedObject{
+		Data:            data,
+		ContentType:     stale.ContentType,
+		ContentEncoding: stale.ContentEncoding,
+		ETag:            attrs.Etag,
+		Size:            attrs.Size,
+		Updated:         attrs.Updated,
+	}, cacheTTL)
+	objectCache.MarkRevalidated()
+
+	logger.WithFields(logrus.Fields{
+		"cache_key": key,
+		"etag":      attrs.Etag,
+	}).Info("Cache entry revalidated with new content")
+}
+
+// serveCachedObject answers a request directly from a cache entry,
+// including conditional-request handling, without contacting GCS. It
+// reports whether the request was fully handled.
+func serveCachedObject(c *gin.Context, cached *CachedObject, state cacheState, requestPath, cacheControlOverride string) bool {
+	ifNoneMatch := c.GetHeader("If-None-Match")
+	if ifNoneMatch != "" && ifNoneMatch == cached.ETag {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+
+	if ifModifiedSince := c.GetHeader("If-Modified-Since"); ifModifiedSince != "" {
+		if modTime, err := time.Parse(time.RFC1123, ifModifiedSince); err == nil {
+			if !cached.Updated.After(modTime) {
+				c.Status(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+
+	cacheStatus := "HIT"
+	if state == cacheStale {
+		cacheStatus = "STALE"
+	}
+
+	cacheControl := cacheControlOverride
+	if cacheControl == "" {
+		cacheControl = getCacheControl(requestPath)
+	}
+
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Cache-Control", cacheControl)
+	c.Header("ETag", cached.ETag)
+	c.Header("Last-Modified", cached.Updated.Format(time.RFC1123))
+	c.Header("X-GCS-Object", requestPath)
+	c.Header("X-Proxy-Cache", cacheStatus)
+	c.Header("Content-Type", cached.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(int64(len(cached.Data)), 10))
+	if cached.ContentEncoding != "" {
+		c.Header("Content-Encoding", cached.ContentEncoding)
+		c.Header("Vary", "Accept-Encoding")
+	}
+	c.Status(http.StatusOK)
+	c.Writer.Write(cached.Data)
+
+	return true
+}
+
+type cacheEntry struct {
+	obj       *CachedObject
+	expiresAt time.Time
+}
+
+func (e *cacheEntry) stale() bool {
+	return time.Now().After(e.expiresAt)
+}
+
+// memoryCache is an in-process LRU tier, evicting by both item count and a
+// total byte budget.
+type memoryCache struct {
+	mu       sync.Mutex
+	lru      *lru.Cache[string, *cacheEntry]
+	maxBytes int64
+	curBytes int64
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	stale       atomic.Uint64
+	revalidated atomic.Uint64
+}
+
+func newMemoryCache(maxItems int, maxBytes int64) (*memoryCache, error) {
+	mc := &memoryCache{maxBytes: maxBytes}
+
+	l, err := lru.NewWithEvict[string, *cacheEntry](maxItems, func(_ string, e *cacheEntry) {
+		atomic.AddInt64(&mc.curBytes, -int64(len(e.obj.Data)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mc.lru = l
+	return mc, nil
+}
+
+func (c *memoryCache) Lookup(key string) (*CachedObject, cacheState) {
+	c.mu.Lock()
+	entry, ok := c.lru.Get(key)
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, cacheMiss
+	}
+
+	if entry.stale() {
+		c.stale.Add(1)
+		return entry.obj, cacheStale
+	}
+
+	c.hits.Add(1)
+	return entry.obj, cacheFresh
+}
+
+func (c *memoryCache) Store(key string, obj *CachedObject, ttl time.Duration) {
+	entry := &cacheEntry{obj: obj, expiresAt: time.Now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Replacing a key does not fire the evict callback, so drop the old
+	// entry's bytes from the tally here.
+	if old, ok := c.lru.Peek(key); ok {
+		atomic.AddInt64(&c.curBytes, -int64(len(old.obj.Data)))
+	}
+	c.lru.Add(key, entry)
+	atomic.AddInt64(&c.curBytes, int64(len(obj.Data)))
+
+	for atomic.LoadInt64(&c.curBytes) > c.maxBytes {
+		if _, _, ok := c.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (c *memoryCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Remove(key)
+}
+
+func (c *memoryCache) MarkRevalidated() {
+	c.revalidated.Add(1)
+}
+
+func (c *memoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	items := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Stale:       c.stale.Load(),
+		Revalidated: c.revalidated.Load(),
+		Items:       items,
+		Bytes:       atomic.LoadInt64(&c.curBytes),
+	}
+}
+
+// diskCacheMeta is the JSON sidecar stored next to each cached object's
+// bytes on disk.
+type diskCacheMeta struct {
+	ContentType     string    `json:"content_type"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	ETag            string    `json:"etag"`
+	Size            int64     `json:"size"`
+	Updated         time.Time `json:"updated"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// diskCache is an optional second tier that every stored object is
+// written through to, surviving memory eviction and process restarts.
+type diskCache struct {
+	dir string
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func newDiskCache(dir string) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir}, nil
+}
+
+func (c *diskCache) paths(key string) (dataPath, metaPath string) {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name+".data"), filepath.Join(c.dir, name+".json")
+}
+
+func (c *diskCache) Lookup(key string) (*CachedObject, cacheState) {
+	dataPath, metaPath := c.paths(key)
+
+	metaBytes, err := os.ReadFile(metaPath)
+	if err != nil {
+		c.misses.Add(1)
+		return nil, cacheMiss
+	}
+
+	var meta diskCacheMeta
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		c.misses.Add(1)
+		return nil, cacheMiss
+	}
+
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		c.misses.Add(1)
+		return nil, cacheMiss
+	}
+
+	obj := &CachedObject{
+		Data:            data,
+		ContentType:     meta.ContentType,
+		ContentEncoding: meta.ContentEncoding,
+		ETag:            meta.ETag,
+		Size:            meta.Size,
+		Updated:         meta.Updated,
+	}
+
+	if time.Now().After(meta.ExpiresAt) {
+		c.misses.Add(1)
+		return obj, cacheStale
+	}
+
+	c.hits.Add(1)
+	return obj, cacheFresh
+}
+
+func (c *diskCache) Store(key string, obj *CachedObject, ttl time.Duration) {
+	dataPath, metaPath := c.paths(key)
+
+	if err := os.WriteFile(dataPath, obj.Data, 0o644); err != nil {
+		logger.WithError(err).Warn("Failed to write disk cache entry")
+		return
+	}
+
+	meta := diskCacheMeta{
+		ContentType:     obj.ContentType,
+		ContentEncoding: obj.ContentEncoding,
+		ETag:            obj.ETag,
+		Size:            obj.Size,
+		Updated:         obj.Updated,
+		ExpiresAt:       time.Now().Add(ttl),
+	}
+
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to marshal disk cache metadata")
+		return
+	}
+
+	if err := os.WriteFile(metaPath, metaBytes, 0o644); err != nil {
+		logger.WithError(err).Warn("Failed to write disk cache metadata")
+	}
+}
+
+func (c *diskCache) Invalidate(key string) {
+	dataPath, metaPath := c.paths(key)
+	os.Remove(dataPath)
+	os.Remove(metaPath)
+}
+
+func (c *diskCache) MarkRevalidated() {}
+
+func (c *diskCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// tieredCache checks the in-memory tier first, falling back to the disk
+// tier (when enabled) and promoting disk hits back into memory.
+type tieredCache struct {
+	mem  *memoryCache
+	disk Cache
+}
+
+func (c *tieredCache) Lookup(key string) (*CachedObject, cacheState) {
+	if obj, state := c.mem.Lookup(key); state != cacheMiss {
+		return obj, state
+	}
+
+	if c.disk == nil {
+		return nil, cacheMiss
+	}
+
+	obj, state := c.disk.Lookup(key)
+	if state == cacheFresh {
+		// Only a confirmed-fresh disk hit is safe to promote: storing a
+		// stale entry here would reset its expiresAt to a fresh TTL, so
+		// revalidateCache's background ETag re-check would never fire
+		// again until that new TTL lapses.
+		c.mem.Store(key, obj, cacheTTL)
+	}
+	return obj, state
+}
+
+func (c *tieredCache) Store(key string, obj *CachedObject, ttl time.Duration) {
+	c.mem.Store(key, obj, ttl)
+	if c.disk != nil {
+		c.disk.Store(key, obj, ttl)
+	}
+}
+
+func (c *tieredCache) Invalidate(key string) {
+	c.mem.Invalidate(key)
+	if c.disk != nil {
+		c.disk.Invalidate(key)
+	}
+}
+
+func (c *tieredCache) MarkRevalidated() {
+	c.mem.MarkRevalidated()
+}
+
+func (c *tieredCache) Stats() CacheStats {
+	return c.mem.Stats()
+}
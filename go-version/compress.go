@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+)
+
+// compressibleTypePrefixes lists Content-Type prefixes worth spending CPU
+// to compress. Images, PDFs, and other already-compressed formats are
+// deliberately excluded.
+var compressibleTypePrefixes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/xml",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+func isCompressible(contentType string) bool {
+	for _, prefix := range compressibleTypePrefixes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableEncodings lists the content-encodings negotiateEncoding can
+// return, i.e. the suffixes proxyHandler may append to a cache key.
+var cacheableEncodings = []string{"br", "gzip"}
+
+// negotiateEncoding picks the best content-encoding to serve for a
+// client's Accept-Encoding header, preferring br over gzip.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		enc = strings.TrimSpace(strings.SplitN(enc, ";", 2)[0])
+		accepted[enc] = true
+	}
+
+	switch {
+	case accepted["br"]:
+		return "br"
+	case accepted["gzip"]:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+func encodingSuffix(encoding string) string {
+	switch encoding {
+	case "br":
+		return ".br"
+	case "gzip":
+		return ".gz"
+	default:
+		return ""
+	}
+}
+
+// compressData compresses data for the given encoding ("br", "gzip", or
+// "" for a no-op passthrough).
+func compressData(data []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch encoding {
+	case "br":
+		w := brotli.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+	default:
+		return data, nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fetchPrecompressedSibling looks for a sibling object (requestPath plus
+// ".br"/".gz"), mirroring nginx's gzip_static, and returns it ready to
+// cache and serve. The ETag carries an encoding suffix so it never
+// collides with the uncompressed object's ETag.
+func fetchPrecompressedSibling(ctx context.Context, bucket *storage.BucketHandle, requestPath, encoding, contentType, etag string) (*CachedObject, bool) {
+	suffix := encodingSuffix(encoding)
+	if suffix == "" {
+		return nil, false
+	}
+
+	sibling := bucket.Object(requestPath + suffix)
+
+	attrs, err := sibling.Attrs(ctx)
+	if err != nil {
+		return nil, false
+	}
+
+	reader, err := sibling.NewReader(ctx)
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false
+	}
+
+	return &CachedObject{
+		Data:            data,
+		ContentType:     contentType,
+		ContentEncoding: encoding,
+		ETag:            etag + suffix,
+		Size:            attrs.Size,
+		Updated:         attrs.Updated,
+	}, true
+}
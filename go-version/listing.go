@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// listingMarkerObject opts a single directory into listing, overriding
+	// directoryListingEnabled being off.
+	listingMarkerObject = ".listing-enabled"
+
+	directoryListingPageSize     = 1000
+	directoryListingCacheControl = "public, max-age=30" // short TTL, distinct from getCacheControl
+)
+
+var directoryListingEnabled bool
+
+func initializeDirectoryListing() {
+	directoryListingEnabled = os.Getenv("ENABLE_DIRECTORY_LISTING") == "true"
+	if directoryListingEnabled {
+		logger.Info("Directory listing enabled")
+	}
+}
+
+// DirectoryEntry describes a single child object or common prefix rendered
+// in a directory listing.
+type DirectoryEntry struct {
+	Name         string `json:"name"`
+	Path         string `json:"path"`
+	IsDir        bool   `json:"is_dir"`
+	Size         int64  `json:"size,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// DirectoryListing is the JSON/HTML representation of one listing page.
+type DirectoryListing struct {
+	Path          string           `json:"path"`
+	Entries       []DirectoryEntry `json:"entries"`
+	NextPageToken string           `json:"next_page_token,omitempty"`
+}
+
+// directoryListingAllowed reports whether a listing may be generated for
+// prefix: either the global env flag is on, or the directory opts in with
+// a ".listing-enabled" marker object.
+func directoryListingAllowed(ctx context.Context, bucket *storage.BucketHandle, prefix string) bool {
+	if directoryListingEnabled {
+		return true
+	}
+
+	_, err := bucket.Object(prefix + listingMarkerObject).Attrs(ctx)
+	return err == nil
+}
+
+// listDirectory lists the immediate children of prefix (one path segment
+// deep, via the "/" delimiter) and writes an HTML or JSON response,
+// content-negotiated via Accept.
+func listDirectory(c *gin.Context, ctx context.Context, bucket *storage.BucketHandle, prefix string) {
+	it := bucket.Objects(ctx, &storage.Query{
+		Prefix:    prefix,
+		Delimiter: "/",
+	})
+
+	pageInfo := it.PageInfo()
+	pageInfo.MaxSize = directoryListingPageSize
+	pageInfo.Token = c.Query("pageToken")
+
+	listing := DirectoryListing{Path: prefix}
+
+	for len(listing.Entries) < pageInfo.MaxSize {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"prefix": prefix,
+				"error":  err.Error(),
+			}).Error("Failed to list bucket objects")
+
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Internal server error",
+				Message: "Failed to list directory",
+			})
+			return
+		}
+
+		if attrs.Prefix != "" {
+			listing.Entries = append(listing.Entries, DirectoryEntry{
+				Name:  strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, prefix), "/"),
+				Path:  attrs.Prefix,
+				IsDir: true,
+			})
+			continue
+		}
+
+		if attrs.Name == prefix || strings.HasSuffix(attrs.Name, listingMarkerObject) {
+			continue // the directory placeholder or marker object itself
+		}
+
+		listing.Entries = append(listing.Entries, DirectoryEntry{
+			Name:         strings.TrimPrefix(attrs.Name, prefix),
+			Path:         attrs.Name,
+			Size:         attrs.Size,
+			ContentType:  attrs.ContentType,
+			LastModified: attrs.Updated.Format(time.RFC1123),
+		})
+	}
+	listing.NextPageToken = it.PageInfo().Token
+
+	sort.Slice(listing.Entries, func(i, j int) bool {
+		return listing.Entries[i].Name < listing.Entries[j].Name
+	})
+
+	c.Header("Cache-Control", directoryListingCacheControl)
+
+	if wantsJSONListing(c) {
+		c.JSON(http.StatusOK, listing)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(renderDirectoryListingHTML(listing)))
+}
+
+// wantsJSONListing inspects Accept to decide between a JSON and an HTML
+// directory listing, preferring HTML (the browsable default) unless the
+// client asks for JSON specifically.
+func wantsJSONListing(c *gin.Context) bool {
+	accept := c.GetHeader("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+func renderDirectoryListingHTML(listing DirectoryListing) string {
+	var b strings.Builder
+
+	title := "/" + listing.Path
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><title>Index of %s</title></head>\n<body>\n", html.EscapeString(title))
+	fmt.Fprintf(&b, "<h1>Index of %s</h1>\n<ul>\n", html.EscapeString(title))
+
+	for _, entry := range listing.Entries {
+		name := entry.Name
+		if entry.IsDir {
+			name += "/"
+		}
+
+		fmt.Fprintf(&b, `<li><a href="%s">%s</a>`, html.EscapeString(name), html.EscapeString(name))
+		if !entry.IsDir {
+			fmt.Fprintf(&b, " - %d bytes - %s", entry.Size, html.EscapeString(entry.LastModified))
+		}
+		b.WriteString("</li>\n")
+	}
+
+	b.WriteString("</ul>\n")
+
+	if listing.NextPageToken != "" {
+		fmt.Fprintf(&b, `<a href="?pageToken=%s">Next page</a>`+"\n", html.EscapeString(listing.NextPageToken))
+	}
+
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
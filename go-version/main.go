@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -27,9 +27,10 @@ var (
 
 // HealthResponse represents the health check response
 type HealthResponse struct {
-	Status    string `json:"status"`
-	Timestamp string `json:"timestamp"`
-	Version   string `json:"version"`
+	Status    string      `json:"status"`
+	Timestamp string      `json:"timestamp"`
+	Version   string      `json:"version"`
+	Cache     *CacheStats `json:"cache,omitempty"`
 }
 
 // ErrorResponse represents an error response
@@ -53,21 +54,37 @@ func init() {
 	gin.SetMode(ginMode)
 }
 
-func initializeGCS() error {
+// initializeGCS creates the package-level storage client and resolves the
+// bucket name from the environment. With opts supplied (e.g. by a test
+// harness pointing at a fake GCS server), those options are used verbatim
+// instead of the usual ADC/key-file resolution, so tests can stand up a
+// client without reaching into package-level globals themselves.
+func initializeGCS(opts ...option.ClientOption) error {
 	ctx := context.Background()
-	
+
 	var err error
-	
+
 	// Get project ID and bucket name from environment
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT_ID")
 	bucketName = os.Getenv("GCS_BUCKET_NAME")
-	keyFile := os.Getenv("GOOGLE_CLOUD_KEY_FILE")
 
 	if bucketName == "" {
 		bucketName = "test-bucket" // Default for testing
 		logger.Warn("GCS_BUCKET_NAME not set, using test-bucket")
 	}
 
+	if len(opts) > 0 {
+		storageClient, err = storage.NewClient(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to create storage client: %v", err)
+		}
+
+		logger.Info("GCS client initialized with injected client options")
+		return nil
+	}
+
+	keyFile := os.Getenv("GOOGLE_CLOUD_KEY_FILE")
+
 	// Skip GCS initialization if no project ID (test mode)
 	if projectID == "" {
 		logger.Info("No GOOGLE_CLOUD_PROJECT_ID set - running in test mode")
@@ -98,6 +115,8 @@ func initializeGCS() error {
 		}(),
 	}).Info("GCS client initialized successfully")
 
+	initializePubSub(projectID, keyFile)
+
 	return nil
 }
 
@@ -107,24 +126,50 @@ func healthHandler(c *gin.Context) {
 		Timestamp: time.Now().Format(time.RFC3339),
 		Version:   "1.0.0",
 	}
+
+	if objectCache != nil {
+		stats := objectCache.Stats()
+		response.Cache = &stats
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		origin := "*"
+		if router != nil {
+			if route, ok := router.Resolve(c.Request.Host, c.Request.URL.Path); ok && len(route.CORSOrigins) > 0 {
+				origin = matchCORSOrigin(route.CORSOrigins, c.GetHeader("Origin"))
+			}
+		}
+
+		if origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
 		c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		
+
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusOK)
 			return
 		}
-		
+
 		c.Next()
 	}
 }
 
+// matchCORSOrigin returns the allowed-origins entry for a route that
+// should be echoed back, or "" if the request's Origin isn't allowed.
+func matchCORSOrigin(allowed []string, requestOrigin string) string {
+	for _, origin := range allowed {
+		if origin == "*" || origin == requestOrigin {
+			return origin
+		}
+	}
+	return ""
+}
+
 func securityMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Security headers (similar to Helmet.js)
@@ -217,43 +262,103 @@ func proxyHandler(c *gin.Context) {
 	}
 
 	startTime := time.Now()
-	requestPath := c.Request.URL.Path
-	
-	// Remove leading slash and handle root path
-	if requestPath == "/" || requestPath == "" {
+
+	route, ok := router.Resolve(c.Request.Host, c.Request.URL.Path)
+	if !ok {
+		logger.WithField("host", c.Request.Host).Warn("No route matched and no default bucket configured")
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Service unavailable",
+			Message: "No bucket configured for this request",
+		})
+		return
+	}
+
+	if !authorizeRoute(c, route) {
+		return
+	}
+
+	requestPath := route.Object
+
+	// Handle the bucket/prefix root
+	if requestPath == "" {
 		requestPath = "index.html"
-	} else if strings.HasPrefix(requestPath, "/") {
-		requestPath = requestPath[1:] // Remove leading slash
 	}
-	if strings.HasSuffix(requestPath, "/") {
+
+	isDirectoryRequest := strings.HasSuffix(requestPath, "/")
+	if isDirectoryRequest {
 		requestPath = requestPath + "index.html"
 	}
-	
-	// Remove leading slash if present
-	if strings.HasPrefix(requestPath, "/") {
-		requestPath = requestPath[1:]
-	}
-	
+
 	logger.WithFields(logrus.Fields{
 		"object_name": requestPath,
-		"bucket":      bucketName,
+		"bucket":      route.Bucket,
 	}).Info("Proxying request")
-	
+
 	ctx := context.Background()
-	
+
 	// Get object handle
-	bucket := storageClient.Bucket(bucketName)
+	bucket := router.bucketHandle(route.Bucket)
 	obj := bucket.Object(requestPath)
-	
+
+	rangeHeader := c.GetHeader("Range")
+
+	contentType := getContentType(requestPath)
+	cacheControl := route.CacheControl
+	if cacheControl == "" {
+		cacheControl = getCacheControl(requestPath)
+	}
+
+	// Negotiate a compressed variant for compressible types, unless the
+	// route asked us not to transform the response.
+	encoding := ""
+	if isCompressible(contentType) && !strings.Contains(cacheControl, "no-transform") {
+		encoding = negotiateEncoding(c.GetHeader("Accept-Encoding"))
+	}
+
+	cacheKey := route.Bucket + "/" + requestPath
+	if encoding != "" {
+		cacheKey += "|" + encoding
+	}
+
+	// Serve straight from cache when possible, without contacting GCS.
+	// Range requests bypass the whole-object cache and fall through below.
+	if rangeHeader == "" && objectCache != nil {
+		if cached, state := objectCache.Lookup(cacheKey); state != cacheMiss {
+			cacheStatus := "HIT"
+			if state == cacheStale {
+				cacheStatus = "STALE"
+				go revalidateCache(cacheKey, obj, cached)
+			}
+
+			logger.WithFields(logrus.Fields{
+				"object_name": requestPath,
+				"cache":       cacheStatus,
+			}).Info("Serving from cache")
+
+			serveCachedObject(c, cached, state, requestPath, route.CacheControl)
+			return
+		}
+	}
+
 	// Check if object exists and get attributes
 	attrs, err := obj.Attrs(ctx)
 	if err != nil {
-		if err == storage.ErrObjectNotExist {
+		// The client wraps ErrObjectNotExist rather than returning it
+		// directly, so it must be unwrapped rather than compared with ==.
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			if isDirectoryRequest {
+				dirPrefix := strings.TrimSuffix(requestPath, "index.html")
+				if directoryListingAllowed(ctx, bucket, dirPrefix) {
+					listDirectory(c, ctx, bucket, dirPrefix)
+					return
+				}
+			}
+
 			logger.WithFields(logrus.Fields{
 				"object_name": requestPath,
 				"error":       "file not found",
 			}).Warn("File not found")
-			
+
 			c.JSON(http.StatusNotFound, ErrorResponse{
 				Error: "File not found",
 				Path:  requestPath,
@@ -302,48 +407,119 @@ func proxyHandler(c *gin.Context) {
 		}
 	}
 	
+	// Large objects are offloaded straight to GCS via a signed URL rather
+	// than streamed through the proxy; fall through to normal serving if
+	// signing fails for any reason.
+	if shouldRedirectToSignedURL(attrs) {
+		if err := redirectToSignedURL(ctx, c, bucket, requestPath); err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": requestPath,
+				"size":        attrs.Size,
+				"error":       err.Error(),
+			}).Error("Failed to generate signed URL, falling back to proxying object")
+		} else {
+			logger.WithFields(logrus.Fields{
+				"object_name": requestPath,
+				"size":        attrs.Size,
+			}).Info("Redirected to signed URL")
+			return
+		}
+	}
+
 	// Set response headers
-	contentType := getContentType(requestPath)
-	cacheControl := getCacheControl(requestPath)
-	
-	c.Header("Content-Type", contentType)
+	c.Header("Accept-Ranges", "bytes")
 	c.Header("Cache-Control", cacheControl)
 	c.Header("ETag", attrs.Etag)
 	c.Header("Last-Modified", attrs.Updated.Format(time.RFC1123))
-	c.Header("Content-Length", strconv.FormatInt(attrs.Size, 10))
-	c.Header("X-Proxy-Cache", "MISS")
 	c.Header("X-GCS-Object", requestPath)
 	c.Header("X-Response-Time", time.Since(startTime).String())
-	
-	// Create reader and stream content
-	reader, err := obj.NewReader(ctx)
-	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"object_name": requestPath,
-			"error":       err.Error(),
-		}).Error("Failed to create object reader")
-		
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "Internal server error",
-			Message: "Failed to read file",
-		})
-		return
+
+	// Handle Range requests (RFC 7233), honoring If-Range against the ETag
+	// or Last-Modified so a stale client falls back to the full object.
+	if rangeHeader != "" {
+		ifRange := c.GetHeader("If-Range")
+		if ifRangeSatisfied(ifRange, attrs.Etag, attrs.Updated) {
+			ranges, err := parseRange(rangeHeader, attrs.Size)
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"object_name": requestPath,
+					"range":       rangeHeader,
+					"error":       err.Error(),
+				}).Warn("416 Range Not Satisfiable")
+
+				c.Header("Content-Range", fmt.Sprintf("bytes */%d", attrs.Size))
+				c.Header("X-Proxy-Cache", "MISS")
+				c.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+
+			if ranges != nil {
+				c.Header("X-Proxy-Cache", "MISS")
+				serveRange(ctx, c, obj, attrs, ranges, contentType)
+
+				logger.WithFields(logrus.Fields{
+					"object_name":   requestPath,
+					"range":         rangeHeader,
+					"response_time": time.Since(startTime).String(),
+				}).Info("Successfully served partial content")
+				return
+			}
+		}
 	}
-	defer reader.Close()
-	
-	// Stream the content directly to the response
+
+	c.Header("X-Proxy-Cache", "MISS")
+
+	// Prefer a precomputed sibling object (path.br/path.gz, mirroring
+	// nginx's gzip_static) over compressing on the fly.
+	var cached *CachedObject
+	if encoding != "" {
+		if sibling, ok := fetchPrecompressedSibling(ctx, bucket, requestPath, encoding, contentType, attrs.Etag); ok {
+			cached = sibling
+			if objectCache != nil {
+				objectCache.Store(cacheKey, cached, cacheTTL)
+			}
+		}
+	}
+
+	if cached == nil {
+		// Fetch the object body, coalescing concurrent misses for the same
+		// key via singleflight and populating the cache for subsequent
+		// requests, compressing it for encoding if negotiated.
+		var err error
+		cached, err = fetchAndCache(ctx, obj, cacheKey, attrs, contentType, encoding)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": requestPath,
+				"error":       err.Error(),
+			}).Error("Failed to fetch object content")
+
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Internal server error",
+				Message: "Failed to read file",
+			})
+			return
+		}
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.FormatInt(int64(len(cached.Data)), 10))
+	c.Header("ETag", cached.ETag)
+	if cached.ContentEncoding != "" {
+		c.Header("Content-Encoding", cached.ContentEncoding)
+		c.Header("Vary", "Accept-Encoding")
+	}
+
 	c.Status(http.StatusOK)
-	
-	bytesWritten, err := io.Copy(c.Writer, reader)
+	bytesWritten, err := c.Writer.Write(cached.Data)
 	if err != nil {
 		logger.WithFields(logrus.Fields{
-			"object_name":    requestPath,
-			"bytes_written":  bytesWritten,
-			"error":          err.Error(),
+			"object_name":   requestPath,
+			"bytes_written": bytesWritten,
+			"error":         err.Error(),
 		}).Error("Failed to stream object content")
 		return
 	}
-	
+
 	logger.WithFields(logrus.Fields{
 		"object_name":   requestPath,
 		"bytes_served":  bytesWritten,
@@ -376,7 +552,19 @@ func main() {
 	if err := initializeGCS(); err != nil {
 		logger.WithError(err).Warn("Failed to initialize GCS client - continuing in test mode")
 	}
-	
+
+	// Initialize the object cache
+	initializeCache()
+
+	// Initialize directory listing support
+	initializeDirectoryListing()
+
+	// Initialize multi-bucket routing
+	initializeRouter()
+
+	// Initialize signed-URL redirects for large objects
+	initializeSignedURLRedirect()
+
 	// Only close client if it was successfully created
 	if storageClient != nil {
 		defer storageClient.Close()
@@ -404,7 +592,9 @@ func main() {
 		<-sigterm
 		
 		logger.Info("Shutting down server...")
-		
+
+		shutdownPubSub()
+
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		
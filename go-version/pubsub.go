@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+)
+
+// gcsNotificationPayload is the JSON body of a GCS Pub/Sub object change
+// notification, as configured via `gsutil notification create`.
+type gcsNotificationPayload struct {
+	Bucket     string `json:"bucket"`
+	Name       string `json:"name"`
+	Generation string `json:"generation"`
+}
+
+const (
+	eventObjectFinalize       = "OBJECT_FINALIZE"
+	eventObjectMetadataUpdate = "OBJECT_METADATA_UPDATE"
+	eventObjectDelete         = "OBJECT_DELETE"
+	eventObjectArchive        = "OBJECT_ARCHIVE"
+
+	pubsubMaxBackoff = 30 * time.Second
+)
+
+var pubsubCancel context.CancelFunc
+
+// initializePubSub starts the optional Pub/Sub subscriber that invalidates
+// cache entries on GCS object change notifications. It is a no-op unless
+// PUBSUB_SUBSCRIPTION (and a project ID) are configured. keyFile, if set,
+// is the same GOOGLE_CLOUD_KEY_FILE used for GCS access, so the subscriber
+// authenticates as the same service account rather than falling back to
+// ambient ADC.
+func initializePubSub(projectID, keyFile string) {
+	subName := os.Getenv("PUBSUB_SUBSCRIPTION")
+	if subName == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsubCancel = cancel
+
+	go runPubSubReceiver(ctx, projectID, subName, keyFile)
+}
+
+// runPubSubReceiver receives notifications until ctx is canceled,
+// reconnecting with exponential backoff on error.
+func runPubSubReceiver(ctx context.Context, projectID, subName, keyFile string) {
+	backoff := time.Second
+
+	var opts []option.ClientOption
+	if keyFile != "" {
+		opts = append(opts, option.WithCredentialsFile(keyFile))
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		client, err := pubsub.NewClient(ctx, projectID, opts...)
+		if err != nil {
+			logger.WithError(err).Error("Failed to create Pub/Sub client, retrying")
+			if !sleepBackoff(ctx, &backoff) {
+				return
+			}
+			continue
+		}
+
+		logger.WithField("subscription", subName).Info("Pub/Sub cache invalidation subscriber started")
+
+		sub := client.Subscription(subName)
+		err = sub.Receive(ctx, handlePubSubMessage)
+		client.Close()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.WithError(err).Error("Pub/Sub receive loop ended unexpectedly, retrying")
+		if !sleepBackoff(ctx, &backoff) {
+			return
+		}
+	}
+}
+
+// sleepBackoff waits out the current backoff (doubling it, capped at
+// pubsubMaxBackoff) unless ctx is canceled first.
+func sleepBackoff(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(*backoff):
+	}
+
+	*backoff *= 2
+	if *backoff > pubsubMaxBackoff {
+		*backoff = pubsubMaxBackoff
+	}
+	return true
+}
+
+// handlePubSubMessage invalidates the cache entry named by a GCS object
+// change notification. Unrecognized event types are acked and ignored.
+func handlePubSubMessage(ctx context.Context, msg *pubsub.Message) {
+	defer msg.Ack()
+
+	eventType := msg.Attributes["eventType"]
+	switch eventType {
+	case eventObjectFinalize, eventObjectMetadataUpdate, eventObjectDelete, eventObjectArchive:
+	default:
+		return
+	}
+
+	var payload gcsNotificationPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		logger.WithError(err).Warn("Failed to decode Pub/Sub notification payload")
+		return
+	}
+
+	if objectCache == nil || payload.Bucket == "" || payload.Name == "" {
+		return
+	}
+
+	key := payload.Bucket + "/" + payload.Name
+	invalidateAllVariants(objectCache, key)
+
+	logger.WithFields(logrus.Fields{
+		"event":  eventType,
+		"bucket": payload.Bucket,
+		"object": payload.Name,
+	}).Info("Invalidated cache entry from Pub/Sub notification")
+}
+
+// shutdownPubSub stops the subscriber goroutine, if one was started.
+func shutdownPubSub() {
+	if pubsubCancel != nil {
+		pubsubCancel()
+	}
+}
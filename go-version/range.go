@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// httpRange represents a single satisfiable byte range of a GCS object.
+type httpRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange formats the Content-Range header value for this range given
+// the full resource size.
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value against a resource of the given
+// size, following RFC 7233. A nil slice and nil error mean no Range header
+// was present, or its unit is not "bytes" and it should be ignored. A
+// non-nil error means the header is malformed, holds more than maxRanges
+// ranges, or holds no satisfiable range, and the caller should respond 416.
+func parseRange(rangeHeader string, size int64) ([]httpRange, error) {
+	if rangeHeader == "" {
+		return nil, nil
+	}
+
+	const prefix = "bytes="
+	if !strings.HasPrefix(rangeHeader, prefix) {
+		// Unknown range unit: ignore per RFC 7233 §3.1 and serve the full body.
+		return nil, nil
+	}
+
+	// maxRanges bounds the number of ranges accepted in a single Range
+	// header. Without it a client can turn one HTTP request into
+	// thousands of separate GCS reads via a "bytes=0-0,2-2,4-4,..." header.
+	const maxRanges = 256
+
+	var ranges []httpRange
+	for _, spec := range strings.Split(rangeHeader[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if len(ranges) >= maxRanges {
+			return nil, fmt.Errorf("too many ranges in %q", rangeHeader)
+		}
+
+		dash := strings.IndexByte(spec, '-')
+		if dash < 0 {
+			return nil, fmt.Errorf("malformed range spec %q", spec)
+		}
+		startStr, endStr := spec[:dash], spec[dash+1:]
+
+		var r httpRange
+		switch {
+		case startStr == "" && endStr == "":
+			return nil, fmt.Errorf("malformed range spec %q", spec)
+
+		case startStr == "":
+			// Suffix range, e.g. "-500" means the last 500 bytes.
+			suffixLength, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || suffixLength <= 0 {
+				return nil, fmt.Errorf("malformed suffix range %q", spec)
+			}
+			if suffixLength > size {
+				suffixLength = size
+			}
+			r = httpRange{start: size - suffixLength, length: suffixLength}
+
+		case endStr == "":
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("malformed range start %q", spec)
+			}
+			if start >= size {
+				continue // not satisfiable, skip per RFC 7233 §2.1
+			}
+			r = httpRange{start: start, length: size - start}
+
+		default:
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("malformed range start %q", spec)
+			}
+			end, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("malformed range end %q", spec)
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			r = httpRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no satisfiable ranges in %q", rangeHeader)
+	}
+
+	return ranges, nil
+}
+
+// ifRangeSatisfied reports whether an If-Range validator still matches the
+// current object. A missing If-Range is satisfied. A value equal to the
+// ETag (quoted or, as GCS sends it, unquoted) or an HTTP date not older
+// than lastModified is satisfied; anything else is not, so callers fall
+// back to a full 200.
+func ifRangeSatisfied(ifRange, etag string, lastModified time.Time) bool {
+	if ifRange == "" || ifRange == etag {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == etag
+	}
+
+	if t, err := http.ParseTime(ifRange); err == nil {
+		return !lastModified.After(t)
+	}
+
+	return false
+}
+
+// serveRange writes a 206 Partial Content response for one or more byte
+// ranges of obj, using a single-part body for one range or a
+// multipart/byteranges body for several, per RFC 7233 §4.1.
+func serveRange(ctx context.Context, c *gin.Context, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs, ranges []httpRange, contentType string) {
+	if len(ranges) == 1 {
+		r := ranges[0]
+
+		reader, err := obj.NewRangeReader(ctx, r.start, r.length)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": attrs.Name,
+				"range":       r.contentRange(attrs.Size),
+				"error":       err.Error(),
+			}).Error("Failed to create range reader")
+
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Internal server error",
+				Message: "Failed to read file",
+			})
+			return
+		}
+		defer reader.Close()
+
+		c.Header("Content-Range", r.contentRange(attrs.Size))
+		c.Header("Content-Length", strconv.FormatInt(r.length, 10))
+		c.Header("Content-Type", contentType)
+		c.Status(http.StatusPartialContent)
+
+		if _, err := io.Copy(c.Writer, reader); err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": attrs.Name,
+				"range":       r.contentRange(attrs.Size),
+				"error":       err.Error(),
+			}).Error("Failed to stream range content")
+		}
+		return
+	}
+
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	c.Status(http.StatusPartialContent)
+
+	for _, r := range ranges {
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Type", contentType)
+		partHeader.Set("Content-Range", r.contentRange(attrs.Size))
+
+		part, err := mw.CreatePart(partHeader)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": attrs.Name,
+				"error":       err.Error(),
+			}).Error("Failed to create multipart range part")
+			return
+		}
+
+		reader, err := obj.NewRangeReader(ctx, r.start, r.length)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": attrs.Name,
+				"range":       r.contentRange(attrs.Size),
+				"error":       err.Error(),
+			}).Error("Failed to create range reader")
+			return
+		}
+
+		_, err = io.Copy(part, reader)
+		reader.Close()
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"object_name": attrs.Name,
+				"range":       r.contentRange(attrs.Size),
+				"error":       err.Error(),
+			}).Error("Failed to stream multipart range content")
+			return
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"object_name": attrs.Name,
+			"error":       err.Error(),
+		}).Error("Failed to close multipart byteranges writer")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// RouteConfig is one routing rule as loaded from GCS_PROXY_CONFIG. A rule
+// matches on Host, PathPrefix, or both; an empty field matches anything.
+type RouteConfig struct {
+	Host         string   `json:"host,omitempty" yaml:"host,omitempty"`
+	PathPrefix   string   `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+	Bucket       string   `json:"bucket" yaml:"bucket"`
+	CacheControl string   `json:"cache_control,omitempty" yaml:"cache_control,omitempty"`
+	CORSOrigins  []string `json:"cors_origins,omitempty" yaml:"cors_origins,omitempty"`
+	JWKSURL      string   `json:"jwks_url,omitempty" yaml:"jwks_url,omitempty"`
+}
+
+// RouterConfig is the top-level shape of the GCS_PROXY_CONFIG file.
+type RouterConfig struct {
+	Routes        []RouteConfig `json:"routes,omitempty" yaml:"routes,omitempty"`
+	DefaultBucket string        `json:"default_bucket,omitempty" yaml:"default_bucket,omitempty"`
+}
+
+// Route is a RouteConfig resolved against a specific request, with its
+// path prefix already stripped from Object.
+type Route struct {
+	Bucket       string
+	Object       string
+	CacheControl string
+	CORSOrigins  []string
+	JWKSURL      string
+}
+
+// Router maps incoming requests to a (bucket, object) tuple based on the
+// Host header, a path prefix, or a default bucket fallback.
+type Router struct {
+	routes        []RouteConfig
+	defaultBucket string
+
+	mu      sync.Mutex
+	buckets map[string]*storage.BucketHandle
+}
+
+var router *Router
+
+func newRouter(cfg RouterConfig, defaultBucket string) *Router {
+	if defaultBucket == "" {
+		defaultBucket = cfg.DefaultBucket
+	}
+
+	return &Router{
+		routes:        cfg.Routes,
+		defaultBucket: defaultBucket,
+		buckets:       make(map[string]*storage.BucketHandle),
+	}
+}
+
+// initializeRouter loads GCS_PROXY_CONFIG, if set, into the package-level
+// router. With no config file (or a bad one), it falls back to a single
+// default route against bucketName so existing single-bucket deployments
+// keep working unchanged.
+func initializeRouter() {
+	path := os.Getenv("GCS_PROXY_CONFIG")
+	if path == "" {
+		router = newRouter(RouterConfig{}, bucketName)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read GCS_PROXY_CONFIG, falling back to single-bucket routing")
+		router = newRouter(RouterConfig{}, bucketName)
+		return
+	}
+
+	var cfg RouterConfig
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(data, &cfg)
+	} else {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to parse GCS_PROXY_CONFIG, falling back to single-bucket routing")
+		router = newRouter(RouterConfig{}, bucketName)
+		return
+	}
+
+	router = newRouter(cfg, bucketName)
+
+	logger.WithFields(logrus.Fields{
+		"config": path,
+		"routes": len(cfg.Routes),
+	}).Info("Multi-bucket router configured")
+}
+
+// Resolve matches a request's Host and URL path against the configured
+// routes, in order, falling back to the default bucket with the leading
+// slash stripped and no prefix removed.
+func (rt *Router) Resolve(host, urlPath string) (Route, bool) {
+	path := strings.TrimPrefix(urlPath, "/")
+
+	for _, rc := range rt.routes {
+		if rc.Host != "" && !hostMatches(rc.Host, host) {
+			continue
+		}
+
+		prefix := strings.Trim(rc.PathPrefix, "/")
+		if prefix != "" && path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+
+		object := strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+
+		return Route{
+			Bucket:       rc.Bucket,
+			Object:       object,
+			CacheControl: rc.CacheControl,
+			CORSOrigins:  rc.CORSOrigins,
+			JWKSURL:      rc.JWKSURL,
+		}, true
+	}
+
+	if rt.defaultBucket == "" {
+		return Route{}, false
+	}
+
+	return Route{Bucket: rt.defaultBucket, Object: path}, true
+}
+
+// hostMatches supports an exact Host match or a "*.example.com" wildcard.
+func hostMatches(pattern, host string) bool {
+	host = strings.SplitN(host, ":", 2)[0]
+
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// bucketHandle returns a cached *storage.BucketHandle for name, creating
+// it on first use.
+func (rt *Router) bucketHandle(name string) *storage.BucketHandle {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if handle, ok := rt.buckets[name]; ok {
+		return handle
+	}
+
+	handle := storageClient.Bucket(name)
+	rt.buckets[name] = handle
+	return handle
+}
@@ -0,0 +1,105 @@
+package main
+
+import "testing"
+
+func TestRouterResolvePrefixBoundary(t *testing.T) {
+	rt := newRouter(RouterConfig{
+		Routes: []RouteConfig{
+			{PathPrefix: "/assets/", Bucket: "assets-bucket"},
+		},
+	}, "")
+
+	cases := []struct {
+		name       string
+		path       string
+		wantOK     bool
+		wantBucket string
+		wantObject string
+	}{
+		{"exact prefix with trailing content", "/assets/logo.png", true, "assets-bucket", "logo.png"},
+		{"bare prefix with no trailing slash", "/assets", true, "assets-bucket", ""},
+		{"lookalike prefix is not a boundary match", "/assets-backup/secret.txt", false, "", ""},
+		{"prefix as substring elsewhere is not a match", "/assetsXYZ", false, "", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			route, ok := rt.Resolve("example.com", tc.path)
+			if ok != tc.wantOK {
+				t.Fatalf("Resolve(%q) ok = %v, want %v", tc.path, ok, tc.wantOK)
+			}
+			if !tc.wantOK {
+				return
+			}
+			if route.Bucket != tc.wantBucket {
+				t.Errorf("Resolve(%q) Bucket = %q, want %q", tc.path, route.Bucket, tc.wantBucket)
+			}
+			if route.Object != tc.wantObject {
+				t.Errorf("Resolve(%q) Object = %q, want %q", tc.path, route.Object, tc.wantObject)
+			}
+		})
+	}
+}
+
+func TestRouterResolveHostWildcard(t *testing.T) {
+	rt := newRouter(RouterConfig{
+		Routes: []RouteConfig{
+			{Host: "*.example.com", Bucket: "wildcard-bucket"},
+			{Host: "static.example.com", Bucket: "exact-bucket"},
+		},
+	}, "")
+
+	cases := []struct {
+		name       string
+		host       string
+		path       string
+		wantBucket string
+	}{
+		{"subdomain matches wildcard", "cdn.example.com", "/file.txt", "wildcard-bucket"},
+		{"port is ignored when matching host", "cdn.example.com:8080", "/file.txt", "wildcard-bucket"},
+		{"first matching route wins", "static.example.com", "/file.txt", "wildcard-bucket"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			route, ok := rt.Resolve(tc.host, tc.path)
+			if !ok {
+				t.Fatalf("Resolve(%q, %q) ok = false, want true", tc.host, tc.path)
+			}
+			if route.Bucket != tc.wantBucket {
+				t.Errorf("Resolve(%q, %q) Bucket = %q, want %q", tc.host, tc.path, route.Bucket, tc.wantBucket)
+			}
+		})
+	}
+
+	if _, ok := rt.Resolve("other.com", "/file.txt"); ok {
+		t.Error("Resolve(other.com) ok = true, want false for a non-matching host with no default bucket")
+	}
+}
+
+func TestRouterResolveDefaultBucketFallback(t *testing.T) {
+	rt := newRouter(RouterConfig{
+		Routes: []RouteConfig{
+			{Host: "static.example.com", Bucket: "static-bucket"},
+		},
+	}, "fallback-bucket")
+
+	route, ok := rt.Resolve("other.com", "/some/path.txt")
+	if !ok {
+		t.Fatal("Resolve() ok = false, want true when a default bucket is configured")
+	}
+	if route.Bucket != "fallback-bucket" {
+		t.Errorf("Bucket = %q, want fallback-bucket", route.Bucket)
+	}
+	if route.Object != "some/path.txt" {
+		t.Errorf("Object = %q, want some/path.txt (leading slash stripped, no prefix removed)", route.Object)
+	}
+}
+
+func TestRouterResolveNoMatchNoDefault(t *testing.T) {
+	rt := newRouter(RouterConfig{}, "")
+
+	if _, ok := rt.Resolve("example.com", "/file.txt"); ok {
+		t.Error("Resolve() ok = true, want false with no routes and no default bucket")
+	}
+}